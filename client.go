@@ -0,0 +1,345 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ServiceEntry describes a single discovered service instance, assembled
+// from the PTR, SRV, A/AAAA and TXT records a responder returns for it
+type ServiceEntry struct {
+	Name       string
+	Host       string
+	AddrV4     net.IP
+	AddrV6     net.IP
+	Port       int
+	InfoFields []string
+	TTL        uint32
+
+	hasTXT bool
+	sent   bool
+}
+
+// complete reports whether enough records have been collected for this
+// entry to be considered resolved
+func (s *ServiceEntry) complete() bool {
+	return (s.AddrV4 != nil || s.AddrV6 != nil) && s.Port != 0 && s.hasTXT
+}
+
+// QueryParam configures a Query
+type QueryParam struct {
+	Service             string          // Service to lookup, e.g. "_http._tcp"
+	Domain              string          // Lookup domain, defaults to "local"
+	Timeout             time.Duration   // Time to wait for responses, defaults to 1 second
+	Context             context.Context // Optional context used to cancel the query early
+	WantUnicastResponse bool            // Ask responders for a unicast reply (RFC 6762 section 5.4)
+	Interface           *net.Interface  // Network interface to send/listen on, defaults to the system-assigned multicast interface
+}
+
+// Query looks up the given service over mDNS and returns a channel of
+// ServiceEntry values as they are discovered and resolved. The channel is
+// closed once QueryParam.Timeout elapses or QueryParam.Context is done.
+func Query(params *QueryParam) (<-chan *ServiceEntry, error) {
+	if params == nil {
+		return nil, fmt.Errorf("Missing query parameters")
+	}
+	if params.Domain == "" {
+		params.Domain = "local"
+	}
+	if params.Timeout == 0 {
+		params.Timeout = time.Second
+	}
+	ctx := params.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, params.Timeout)
+
+	c, err := newClient(params.Interface)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if err := c.sendQuery(params); err != nil {
+		cancel()
+		c.close()
+		return nil, err
+	}
+
+	entries := make(chan *ServiceEntry, 32)
+	go func() {
+		defer cancel()
+		defer c.close()
+		defer close(entries)
+		c.run(ctx, entries)
+	}()
+
+	return entries, nil
+}
+
+// client holds the sockets used to send the query and collect responses
+// for a single Query call. Separate sockets are used for sending and
+// receiving: the receive sockets are bound to the mDNS group address with
+// net.ListenMulticastUDP, which sets SO_REUSEADDR/SO_REUSEPORT so Query
+// works even when a system mDNS responder (or this package's own Server)
+// already holds port 5353; the send sockets use an ephemeral port, as
+// only the destination address matters for an outgoing multicast. A
+// responder asked for a unicast reply (QueryParam.WantUnicastResponse)
+// sends its answer back to that ephemeral port, so the send sockets are
+// also read by run/recvLoop, not just written to.
+type client struct {
+	recv4 *net.UDPConn
+	recv6 *net.UDPConn // nil if IPv6 multicast isn't available
+
+	sendConn4 *net.UDPConn
+	send4     *ipv4.PacketConn
+	sendConn6 *net.UDPConn // nil if IPv6 multicast isn't available
+	send6     *ipv6.PacketConn
+}
+
+// newClient opens the send/receive sockets described above, optionally
+// restricted to a single interface. IPv6 is best-effort: hosts with IPv6
+// disabled still get a working IPv4-only client.
+func newClient(iface *net.Interface) (*client, error) {
+	recv4, err := net.ListenMulticastUDP("udp4", iface, &net.UDPAddr{IP: net.ParseIP(mdnsGroupIPv4), Port: 5353})
+	if err != nil {
+		return nil, fmt.Errorf("mdns: failed to join ipv4 multicast group: %v", err)
+	}
+
+	recv6, err := net.ListenMulticastUDP("udp6", iface, &net.UDPAddr{IP: net.ParseIP(mdnsGroupIPv6), Port: 5353})
+	if err != nil {
+		recv6 = nil
+	}
+
+	sendConn4, err := net.ListenUDP("udp4", &net.UDPAddr{Port: 0})
+	if err != nil {
+		recv4.Close()
+		if recv6 != nil {
+			recv6.Close()
+		}
+		return nil, fmt.Errorf("mdns: failed to open ipv4 send socket: %v", err)
+	}
+	send4 := ipv4.NewPacketConn(sendConn4)
+	if iface != nil {
+		send4.SetMulticastInterface(iface)
+	}
+
+	var sendConn6 *net.UDPConn
+	var send6 *ipv6.PacketConn
+	if sendConn6, err = net.ListenUDP("udp6", &net.UDPAddr{Port: 0}); err == nil {
+		send6 = ipv6.NewPacketConn(sendConn6)
+		if iface != nil {
+			send6.SetMulticastInterface(iface)
+		}
+	} else {
+		sendConn6 = nil
+	}
+
+	return &client{
+		recv4:     recv4,
+		recv6:     recv6,
+		sendConn4: sendConn4,
+		send4:     send4,
+		sendConn6: sendConn6,
+		send6:     send6,
+	}, nil
+}
+
+func (c *client) close() {
+	c.recv4.Close()
+	if c.recv6 != nil {
+		c.recv6.Close()
+	}
+	c.sendConn4.Close()
+	if c.sendConn6 != nil {
+		c.sendConn6.Close()
+	}
+}
+
+// sendQuery multicasts a single PTR question for the requested service
+func (c *client) sendQuery(params *QueryParam) error {
+	serviceAddr := fmt.Sprintf("%s.%s.", trimDot(params.Service), trimDot(params.Domain))
+
+	q := dns.Question{
+		Name:   serviceAddr,
+		Qtype:  dns.TypePTR,
+		Qclass: dns.ClassINET,
+	}
+	if params.WantUnicastResponse {
+		q.Qclass |= 1 << 15
+	}
+
+	msg := new(dns.Msg)
+	msg.Question = []dns.Question{q}
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	addr4 := &net.UDPAddr{IP: net.ParseIP(mdnsGroupIPv4), Port: 5353}
+	if _, err := c.send4.WriteTo(buf, nil, addr4); err != nil {
+		return err
+	}
+
+	if c.send6 != nil {
+		addr6 := &net.UDPAddr{IP: net.ParseIP(mdnsGroupIPv6), Port: 5353}
+		// Best effort: IPv6 multicast may be unreachable on some links
+		// even when the socket opened fine
+		c.send6.WriteTo(buf, nil, addr6)
+	}
+	return nil
+}
+
+// run reads responses off all of the client's sockets until the context is done,
+// assembling and publishing complete ServiceEntry values as they resolve
+func (c *client) run(ctx context.Context, entries chan *ServiceEntry) {
+	msgCh := make(chan *dns.Msg, 32)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go recvLoop(c.recv4, msgCh, &wg)
+	// The send socket is also read: a responder answering a QU
+	// (unicast-requested) question replies to our ephemeral source port
+	// rather than the mDNS multicast group
+	go recvLoop(c.sendConn4, msgCh, &wg)
+	if c.recv6 != nil {
+		wg.Add(1)
+		go recvLoop(c.recv6, msgCh, &wg)
+	}
+	if c.sendConn6 != nil {
+		wg.Add(1)
+		go recvLoop(c.sendConn6, msgCh, &wg)
+	}
+	go func() {
+		wg.Wait()
+		close(msgCh)
+	}()
+
+	inflight := make(map[string]*ServiceEntry)
+	// addr records keyed by their owner host name, held until the SRV
+	// record that names that host arrives - an A/AAAA can legally show
+	// up in an earlier packet than the SRV that resolves it to an entry
+	pendingAddrs := make(map[string][]dns.RR)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+			for _, rec := range append(msg.Answer, msg.Extra...) {
+				applyRecord(inflight, pendingAddrs, rec)
+			}
+			for _, ent := range inflight {
+				if ent.complete() && !ent.sent {
+					ent.sent = true
+					// Publish a copy: ent stays in inflight and keeps
+					// being mutated by later packets (e.g. a refreshed
+					// TTL), which would race with the consumer otherwise
+					sent := *ent
+					select {
+					case entries <- &sent:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// recvLoop reads packets off a UDP socket and decodes them as DNS
+// messages until the socket is closed
+func recvLoop(conn *net.UDPConn, msgCh chan *dns.Msg, wg *sync.WaitGroup) {
+	defer wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		msgCh <- msg
+	}
+}
+
+// ensureEntry returns the in-flight entry for the given instance name,
+// creating it if this is the first record seen for it
+func ensureEntry(inflight map[string]*ServiceEntry, name string) *ServiceEntry {
+	ent, ok := inflight[name]
+	if !ok {
+		ent = &ServiceEntry{Name: name}
+		inflight[name] = ent
+	}
+	return ent
+}
+
+// applyRecord folds a single PTR/SRV/A/AAAA/TXT record into the matching
+// in-flight entry, buffering A/AAAA records that arrive before the SRV
+// record that names their host
+func applyRecord(inflight map[string]*ServiceEntry, pendingAddrs map[string][]dns.RR, rec dns.RR) {
+	switch v := rec.(type) {
+	case *dns.PTR:
+		ensureEntry(inflight, v.Ptr)
+	case *dns.SRV:
+		ent := inflight[v.Hdr.Name]
+		if ent == nil {
+			return
+		}
+		ent.Host = v.Target
+		ent.Port = int(v.Port)
+		ent.TTL = v.Hdr.Ttl
+		for _, addrRec := range pendingAddrs[ent.Host] {
+			applyAddr(ent, addrRec)
+		}
+	case *dns.TXT:
+		ent := inflight[v.Hdr.Name]
+		if ent == nil {
+			return
+		}
+		ent.InfoFields = v.Txt
+		ent.hasTXT = true
+	case *dns.A, *dns.AAAA:
+		host := rec.Header().Name
+		pendingAddrs[host] = append(pendingAddrs[host], rec)
+		if ent := entryForHost(inflight, host); ent != nil {
+			applyAddr(ent, rec)
+		}
+	}
+}
+
+// applyAddr copies an A or AAAA record's address into the entry it
+// resolves
+func applyAddr(ent *ServiceEntry, rec dns.RR) {
+	switch v := rec.(type) {
+	case *dns.A:
+		ent.AddrV4 = v.A
+	case *dns.AAAA:
+		ent.AddrV6 = v.AAAA
+	}
+}
+
+// entryForHost finds the in-flight entry whose resolved Host matches the
+// owner name of an A/AAAA record
+func entryForHost(inflight map[string]*ServiceEntry, host string) *ServiceEntry {
+	for _, ent := range inflight {
+		if ent.Host != "" && strings.EqualFold(ent.Host, host) {
+			return ent
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package mdns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// mdnsGroupIPv4 and mdnsGroupIPv6 are the well-known mDNS multicast
+	// group addresses (RFC 6762 section 3)
+	mdnsGroupIPv4 = "224.0.0.251"
+	mdnsGroupIPv6 = "ff02::fb"
+
+	// mdnsIPv4Addr and mdnsIPv6Addr are the mDNS multicast group
+	// addresses with their well-known port attached
+	mdnsIPv4Addr = mdnsGroupIPv4 + ":5353"
+	mdnsIPv6Addr = "[" + mdnsGroupIPv6 + "]:5353"
+)
+
+// GoodbyeRecords returns the resource record set this service would need
+// to announce its own departure: the service-level PTR plus every record
+// instanceRecords would answer for a TypeANY query, each with its TTL set
+// to zero so listening caches evict it immediately (RFC 6762 section 10.1)
+func (m *MDNSService) GoodbyeRecords() []dns.RR {
+	recs := []dns.RR{
+		&dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   m.serviceAddr,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    0,
+			},
+			Ptr: m.instanceAddr,
+		},
+	}
+
+	instRecs := m.instanceRecords(dns.Question{
+		Name:  m.instanceAddr,
+		Qtype: dns.TypeANY,
+	})
+	for _, rr := range instRecs {
+		goodbye := dns.Copy(rr)
+		goodbye.Header().Ttl = 0
+		recs = append(recs, goodbye)
+	}
+
+	return recs
+}
+
+// Shutdown announces that this service is going away by multicasting an
+// unsolicited response containing its GoodbyeRecords with TTL=0, so other
+// mDNS responders on the link evict it from their caches right away
+// instead of waiting out the original TTL.
+func (m *MDNSService) Shutdown() error {
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Answer = m.GoodbyeRecords()
+
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	if len(m.ipv4Addrs) > 0 {
+		if err := sendGoodbyeV4(buf); err != nil {
+			return err
+		}
+	}
+	if len(m.ipv6Addrs) > 0 {
+		// Best effort: link-local IPv6 multicast needs a zone, and the
+		// set of usable interfaces varies by host, so a send failure
+		// here shouldn't fail Shutdown for an otherwise-dual-stack
+		// service that got its IPv4 goodbye out fine
+		sendGoodbyeV6(buf)
+	}
+	return nil
+}
+
+// Goodbye is an alias for Shutdown
+func (m *MDNSService) Goodbye() error {
+	return m.Shutdown()
+}
+
+// sendGoodbyeV4 multicasts a packed goodbye message to the mDNS IPv4
+// multicast group
+func sendGoodbyeV4(buf []byte) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", mdnsIPv4Addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp4", nil, udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(buf)
+	return err
+}
+
+// sendGoodbyeV6 multicasts a packed goodbye message to the mDNS IPv6
+// multicast group on every up, multicast-capable interface, since
+// link-local multicast requires an outgoing zone and no single interface
+// is universally correct
+func sendGoodbyeV6(buf []byte) {
+	addr6, err := net.ResolveUDPAddr("udp6", mdnsIPv6Addr)
+	if err != nil {
+		return
+	}
+
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified, Port: 0})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	pconn := ipv6.NewPacketConn(conn)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if err := pconn.SetMulticastInterface(iface); err != nil {
+			continue
+		}
+		pconn.WriteTo(buf, nil, addr6)
+	}
+}
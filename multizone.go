@@ -0,0 +1,130 @@
+package mdns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// MultiZone is a Zone that aggregates several MDNSService zones behind a
+// single responder, so a host can advertise more than one service type at
+// once. It dispatches each query to every registered service in turn and
+// merges the non-nil results, so the caller only needs to run one server.
+type MultiZone struct {
+	Zones []*MDNSService
+}
+
+// NewMultiZone creates a MultiZone serving the given, already-initialized
+// services
+func NewMultiZone(zones ...*MDNSService) *MultiZone {
+	return &MultiZone{Zones: zones}
+}
+
+// Records implements the Zone interface
+func (z *MultiZone) Records(q dns.Question) []dns.RR {
+	// Answer the service type enumeration meta-query ourselves with a
+	// single deduplicated PTR per distinct service or subtype address, rather than letting
+	// each child answer separately
+	for _, svc := range z.Zones {
+		if q.Name == svc.enumAddr && (q.Qtype == dns.TypePTR || q.Qtype == dns.TypeANY) {
+			return z.enumRecords(q)
+		}
+	}
+
+	// A TypeANY query against the domain apex itself (e.g. "local.") gets
+	// the union of every registered service's PTR, same as the browse
+	// answer, so generic mDNS browsers can enumerate everything at once
+	for _, svc := range z.Zones {
+		if q.Qtype == dns.TypeANY && q.Name == trimDot(svc.Domain)+"." {
+			return z.domainRecords(q)
+		}
+	}
+
+	var recs []dns.RR
+	for _, svc := range z.Zones {
+		recs = append(recs, svc.Records(q)...)
+	}
+	return dedupRecords(recs)
+}
+
+// enumRecords synthesizes a single "_services._dns-sd._udp.<domain>"
+// answer listing every distinct service and subtype address registered
+// across all zones (RFC 6763 section 9)
+func (z *MultiZone) enumRecords(q dns.Question) []dns.RR {
+	seen := make(map[string]bool)
+	var recs []dns.RR
+	for _, svc := range z.Zones {
+		if q.Name != svc.enumAddr {
+			continue
+		}
+		if seen[svc.serviceAddr] {
+			continue
+		}
+		seen[svc.serviceAddr] = true
+		recs = append(recs, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    defaultTTL,
+			},
+			Ptr: svc.serviceAddr,
+		})
+		for _, subtypeAddr := range svc.subtypeAddrs {
+			if seen[subtypeAddr] {
+				continue
+			}
+			seen[subtypeAddr] = true
+			recs = append(recs, &dns.PTR{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypePTR,
+					Class:  dns.ClassINET,
+					Ttl:    defaultTTL,
+				},
+				Ptr: subtypeAddr,
+			})
+		}
+	}
+	return recs
+}
+
+// domainRecords synthesizes the union of every registered service's PTR
+// record for a TypeANY query against the domain apex
+func (z *MultiZone) domainRecords(q dns.Question) []dns.RR {
+	seen := make(map[string]bool)
+	var recs []dns.RR
+	for _, svc := range z.Zones {
+		if trimDot(svc.Domain)+"." != q.Name {
+			continue
+		}
+		if seen[svc.serviceAddr] {
+			continue
+		}
+		seen[svc.serviceAddr] = true
+		recs = append(recs, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    defaultTTL,
+			},
+			Ptr: svc.serviceAddr,
+		})
+	}
+	return recs
+}
+
+// dedupRecords removes identical resource records (matched by their wire
+// string representation), preserving the order they were first seen in
+func dedupRecords(recs []dns.RR) []dns.RR {
+	seen := make(map[string]bool, len(recs))
+	out := make([]dns.RR, 0, len(recs))
+	for _, rr := range recs {
+		key := rr.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, rr)
+	}
+	return out
+}
@@ -13,6 +13,22 @@ const (
 	defaultTTL = 10
 )
 
+// dnssdServiceEnumDomain is the well-known meta-query name used by
+// clients to enumerate the service types advertised on the link.
+// See RFC 6763 section 9.
+const dnssdServiceEnumDomain = "_services._dns-sd._udp"
+
+const (
+	// maxTXTFieldLength is the maximum length of a single TXT character
+	// string, per RFC 6763 section 6.1.
+	maxTXTFieldLength = 255
+
+	// maxTXTRecordLength is the maximum total size of a TXT record's
+	// RDATA we are willing to advertise, so the record comfortably fits
+	// within an mDNS message (RFC 6762 section 17).
+	maxTXTRecordLength = 1300
+)
+
 // Zone is the interface used to integrate with the server and
 // to serve records dynamically
 type Zone interface {
@@ -21,23 +37,86 @@ type Zone interface {
 
 // MDNSService is used to export a named service by implementing a Zone
 type MDNSService struct {
-	Instance string // Instance name (e.g. host name)
-	Service  string // Service name (e.g. _http._tcp.)
-	Port     int    // Service Port
-	Info     string // Service info served as a TXT record
-	Domain   string // If blank, assumes ".local"
+	Instance   string   // Instance name (e.g. host name)
+	Service    string   // Service name (e.g. _http._tcp.)
+	Port       int      // Service Port
+	Info       string   // Service info served as a TXT record
+	InfoFields []string // Service info served as TXT record key/value pairs (e.g. "path=/")
+	Domain     string   // If blank, assumes ".local"
+	Subtypes   []string // DNS-SD subtypes the service belongs to (e.g. "printer")
 
-	HostName string   // Host machine DNS name
+	HostName string // Host machine DNS name
 
-	ipv4Addr net.IP // Host machine IPv4 address
-	ipv6Addr net.IP // Host machine IPv6 address
+	ipv4Addrs []net.IP // Host machine IPv4 addresses
+	ipv6Addrs []net.IP // Host machine IPv6 addresses
 
-	serviceAddr  string // Fully qualified service address
-	instanceAddr string // Fully qualified instance address
+	serviceAddr  string   // Fully qualified service address
+	instanceAddr string   // Fully qualified instance address
+	enumAddr     string   // Fully qualified service type enumeration address
+	subtypeAddrs []string // Fully qualified subtype addresses, one per entry in Subtypes
 }
 
 // Init should be called to setup the internal state
 func (m *MDNSService) Init() error {
+	// Get host information
+	hostName, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Could not determine host")
+	}
+	m.HostName = fmt.Sprintf("%s.", hostName)
+
+	addrs, err := net.LookupIP(hostName)
+	if err != nil {
+		return fmt.Errorf("Could not determine host IP addresses for %s", hostName)
+	}
+
+	for i := 0; i < len(addrs); i++ {
+		if ipv4 := addrs[i].To4(); ipv4 != nil {
+			m.ipv4Addrs = append(m.ipv4Addrs, ipv4)
+		} else if ipv6 := addrs[i].To16(); ipv6 != nil {
+			m.ipv6Addrs = append(m.ipv6Addrs, ipv6)
+		}
+	}
+
+	return m.setup()
+}
+
+// NewProxyMDNSService creates a new MDNSService that advertises a service
+// on behalf of a host that cannot itself speak mDNS, such as a printer or
+// other embedded device. Unlike Init, it does not look up the local
+// hostname or IP addresses and instead uses the supplied values directly.
+func NewProxyMDNSService(instance, service, domain, hostName string, ipv4, ipv6 []net.IP, port int, txt []string) (*MDNSService, error) {
+	if hostName == "" {
+		return nil, fmt.Errorf("Missing host name")
+	}
+
+	// Normalize to a fully qualified, dot-terminated name, matching what
+	// Init derives from os.Hostname(), since HostName is used verbatim as
+	// the SRV target and the A/AAAA owner name
+	if !strings.HasSuffix(hostName, ".") {
+		hostName = hostName + "."
+	}
+
+	m := &MDNSService{
+		Instance:   instance,
+		Service:    service,
+		Domain:     domain,
+		HostName:   hostName,
+		Port:       port,
+		InfoFields: txt,
+		ipv4Addrs:  ipv4,
+		ipv6Addrs:  ipv6,
+	}
+
+	if err := m.setup(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// setup validates the service configuration and computes the fully
+// qualified addresses shared by Init and NewProxyMDNSService
+func (m *MDNSService) setup() error {
 	// Setup default domain
 	if m.Domain == "" {
 		m.Domain = "local"
@@ -54,32 +133,37 @@ func (m *MDNSService) Init() error {
 		return fmt.Errorf("Missing service port")
 	}
 
-	// Get host information
-	hostName, err := os.Hostname()
-	if err == nil {
-		m.HostName = fmt.Sprintf("%s.", hostName)
-
-		addrs, err := net.LookupIP(hostName)
-		if err != nil {
-			return fmt.Errorf("Could not determine host IP addresses for %s", hostName)
-		}
-
-		for i := 0; i < len(addrs); i++ {
-			if ipv4 := addrs[i].To4(); ipv4 != nil {
-				m.ipv4Addr = ipv4
-			} else if ipv6 := addrs[i].To16(); ipv6 != nil {
-				m.ipv6Addr = ipv6
-			}
-		}
-	} else {
-		return fmt.Errorf("Could not determine host")
-	}
-
 	// Create the full addresses
 	m.serviceAddr = fmt.Sprintf("%s.%s.",
 		trimDot(m.Service), trimDot(m.Domain))
 	m.instanceAddr = fmt.Sprintf("%s.%s",
 		trimDot(m.Instance), m.serviceAddr)
+	m.enumAddr = fmt.Sprintf("%s.%s.",
+		dnssdServiceEnumDomain, trimDot(m.Domain))
+
+	m.subtypeAddrs = make([]string, len(m.Subtypes))
+	for i, sub := range m.Subtypes {
+		m.subtypeAddrs[i] = fmt.Sprintf("_%s._sub.%s", trimDot(sub), m.serviceAddr)
+	}
+
+	// Fall back to the legacy single-string Info field if no structured
+	// fields were supplied, so existing callers keep working unchanged
+	if len(m.InfoFields) == 0 {
+		m.InfoFields = []string{m.Info}
+	}
+
+	total := 0
+	for _, field := range m.InfoFields {
+		if len(field) > maxTXTFieldLength {
+			return fmt.Errorf("TXT field %q exceeds maximum length of %d bytes", field, maxTXTFieldLength)
+		}
+		// Each character string is prefixed by a one-byte length in RDATA
+		total += 1 + len(field)
+	}
+	if total > maxTXTRecordLength {
+		return fmt.Errorf("TXT record exceeds maximum length of %d bytes", maxTXTRecordLength)
+	}
+
 	return nil
 }
 
@@ -90,10 +174,52 @@ func trimDot(s string) string {
 
 func (m *MDNSService) Records(q dns.Question) []dns.RR {
 	switch q.Name {
+	case m.enumAddr:
+		return m.serviceEnumRecords(q)
 	case m.serviceAddr:
 		return m.serviceRecords(q)
 	case m.instanceAddr:
 		return m.instanceRecords(q)
+	default:
+		for _, subtypeAddr := range m.subtypeAddrs {
+			if q.Name == subtypeAddr {
+				return m.serviceRecords(q)
+			}
+		}
+		return nil
+	}
+}
+
+// serviceEnumRecords is called when the query matches the service type
+// enumeration meta-query (RFC 6763 section 9)
+func (m *MDNSService) serviceEnumRecords(q dns.Question) []dns.RR {
+	switch q.Qtype {
+	case dns.TypeANY:
+		fallthrough
+	case dns.TypePTR:
+		recs := []dns.RR{
+			&dns.PTR{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypePTR,
+					Class:  dns.ClassINET,
+					Ttl:    defaultTTL,
+				},
+				Ptr: m.serviceAddr,
+			},
+		}
+		for _, subtypeAddr := range m.subtypeAddrs {
+			recs = append(recs, &dns.PTR{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypePTR,
+					Class:  dns.ClassINET,
+					Ttl:    defaultTTL,
+				},
+				Ptr: subtypeAddr,
+			})
+		}
+		return recs
 	default:
 		return nil
 	}
@@ -148,36 +274,42 @@ func (m *MDNSService) instanceRecords(q dns.Question) []dns.RR {
 		return recs
 
 	case dns.TypeA:
-		// Only handle if we have a ipv4 addr
-		if m.ipv4Addr == nil {
+		// Only handle if we have ipv4 addrs
+		if len(m.ipv4Addrs) == 0 {
 			return nil
 		}
-		a := &dns.A{
-			Hdr: dns.RR_Header{
-				Name:   m.HostName,
-				Rrtype: dns.TypeA,
-				Class:  dns.ClassINET,
-				Ttl:    defaultTTL,
-			},
-			A: m.ipv4Addr,
+		recs := make([]dns.RR, 0, len(m.ipv4Addrs))
+		for _, ipv4Addr := range m.ipv4Addrs {
+			recs = append(recs, &dns.A{
+				Hdr: dns.RR_Header{
+					Name:   m.HostName,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    defaultTTL,
+				},
+				A: ipv4Addr,
+			})
 		}
-		return []dns.RR{a}
+		return recs
 
 	case dns.TypeAAAA:
-		// Only handle if we have a ipv6 addr
-		if m.ipv6Addr == nil {
+		// Only handle if we have ipv6 addrs
+		if len(m.ipv6Addrs) == 0 {
 			return nil
 		}
-		a4 := &dns.AAAA{
-			Hdr: dns.RR_Header{
-				Name:   m.HostName,
-				Rrtype: dns.TypeAAAA,
-				Class:  dns.ClassINET,
-				Ttl:    defaultTTL,
-			},
-			AAAA: m.ipv6Addr,
+		recs := make([]dns.RR, 0, len(m.ipv6Addrs))
+		for _, ipv6Addr := range m.ipv6Addrs {
+			recs = append(recs, &dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   m.HostName,
+					Rrtype: dns.TypeAAAA,
+					Class:  dns.ClassINET,
+					Ttl:    defaultTTL,
+				},
+				AAAA: ipv6Addr,
+			})
 		}
-		return []dns.RR{a4}
+		return recs
 
 	case dns.TypeSRV:
 		// Create the SRV Record
@@ -217,7 +349,7 @@ func (m *MDNSService) instanceRecords(q dns.Question) []dns.RR {
 				Class:  dns.ClassINET,
 				Ttl:    defaultTTL,
 			},
-			Txt: []string{m.Info},
+			Txt: m.InfoFields,
 		}
 		return []dns.RR{txt}
 	}